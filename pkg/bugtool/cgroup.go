@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Tetragon
+
+package bugtool
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultMemlockPressureThreshold is the default fraction (0-1) of the
+// cgroup memory limit at which pinned-maps memlock usage is considered
+// risky enough to warrant a warning.
+const DefaultMemlockPressureThreshold = 0.8
+
+const (
+	procSelfCgroup  = "/proc/self/cgroup"
+	cgroupV2Mount   = "/sys/fs/cgroup"
+	cgroupV1MemRoot = "/sys/fs/cgroup/memory"
+)
+
+// CgroupMemoryInfo holds the effective memory limit and current usage of the
+// cgroup that the calling process belongs to.
+type CgroupMemoryInfo struct {
+	// Limit is the configured memory limit in bytes, or -1 if unlimited.
+	Limit int64
+	// Current is the current memory usage in bytes.
+	Current int64
+}
+
+// currentCgroupMemoryInfo reads /proc/self/cgroup to locate the cgroup of the
+// calling process and returns its effective memory limit and current usage,
+// supporting both cgroup v2 (unified hierarchy) and cgroup v1 (memory
+// controller) layouts.
+func currentCgroupMemoryInfo() (CgroupMemoryInfo, error) {
+	relPath, err := selfCgroupMemoryPath()
+	if err != nil {
+		return CgroupMemoryInfo{}, fmt.Errorf("failed to locate cgroup of current process: %w", err)
+	}
+
+	// cgroup v2: a single unified hierarchy rooted at cgroupV2Mount.
+	if limitPath := filepath.Join(cgroupV2Mount, relPath, "memory.max"); fileExists(limitPath) {
+		limit, err := readCgroupV2Value(limitPath)
+		if err != nil {
+			return CgroupMemoryInfo{}, fmt.Errorf("failed to read %q: %w", limitPath, err)
+		}
+		currentPath := filepath.Join(cgroupV2Mount, relPath, "memory.current")
+		current, err := readCgroupIntFile(currentPath)
+		if err != nil {
+			return CgroupMemoryInfo{}, fmt.Errorf("failed to read %q: %w", currentPath, err)
+		}
+		return CgroupMemoryInfo{Limit: limit, Current: current}, nil
+	}
+
+	// cgroup v1: the memory controller is mounted separately.
+	limitPath := filepath.Join(cgroupV1MemRoot, relPath, "memory.limit_in_bytes")
+	if fileExists(limitPath) {
+		limit, err := readCgroupIntFile(limitPath)
+		if err != nil {
+			return CgroupMemoryInfo{}, fmt.Errorf("failed to read %q: %w", limitPath, err)
+		}
+		// cgroup v1 reports an arbitrarily large sentinel instead of -1
+		// for "no limit", normalize it like v2 does.
+		if limit >= 1<<62 {
+			limit = -1
+		}
+		currentPath := filepath.Join(cgroupV1MemRoot, relPath, "memory.usage_in_bytes")
+		current, err := readCgroupIntFile(currentPath)
+		if err != nil {
+			return CgroupMemoryInfo{}, fmt.Errorf("failed to read %q: %w", currentPath, err)
+		}
+		return CgroupMemoryInfo{Limit: limit, Current: current}, nil
+	}
+
+	return CgroupMemoryInfo{}, fmt.Errorf("no cgroup v1 or v2 memory controller found for path %q", relPath)
+}
+
+// selfCgroupMemoryPath parses procSelfCgroup and returns the cgroup path to
+// use to look up memory controller files. On cgroup v2 hosts there is a
+// single line with an empty controller list ("0::/path"); on cgroup v1 hosts
+// the line with "memory" in its controller list is used.
+func selfCgroupMemoryPath() (string, error) {
+	f, err := os.Open(procSelfCgroup)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %q: %w", procSelfCgroup, err)
+	}
+	defer f.Close()
+	return cgroupMemoryPathFromReader(f)
+}
+
+// cgroupMemoryPathFromReader parses the contents of a /proc/<pid>/cgroup
+// file and returns the cgroup path to use to look up memory controller
+// files: the path of the "memory" entry on cgroup v1 hosts, or the unified
+// (empty controller list) entry on cgroup v2 hosts.
+func cgroupMemoryPathFromReader(r io.Reader) (string, error) {
+	var unified string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// format is hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		controllers, path := fields[1], fields[2]
+		if controllers == "" {
+			unified = path
+			continue
+		}
+		for _, c := range strings.Split(controllers, ",") {
+			if c == "memory" {
+				return path, nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to scan cgroup file: %w", err)
+	}
+	if unified != "" {
+		return unified, nil
+	}
+	return "", fmt.Errorf("no memory controller entry found")
+}
+
+func readCgroupV2Value(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return -1, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q: %w", value, err)
+	}
+	return limit, nil
+}
+
+func readCgroupIntFile(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q: %w", strings.TrimSpace(string(data)), err)
+	}
+	return value, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
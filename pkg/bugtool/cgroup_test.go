@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Tetragon
+
+package bugtool
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCgroupMemoryPathFromReaderV1(t *testing.T) {
+	const data = `12:pids:/user.slice
+11:memory:/user.slice/user-1000.slice
+10:cpu,cpuacct:/user.slice
+`
+	path, err := cgroupMemoryPathFromReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "/user.slice/user-1000.slice"; path != want {
+		t.Errorf("got path %q, want %q", path, want)
+	}
+}
+
+func TestCgroupMemoryPathFromReaderV2(t *testing.T) {
+	const data = `0::/user.slice/user-1000.slice/session-1.scope
+`
+	path, err := cgroupMemoryPathFromReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "/user.slice/user-1000.slice/session-1.scope"; path != want {
+		t.Errorf("got path %q, want %q", path, want)
+	}
+}
+
+func TestCgroupMemoryPathFromReaderNoMemoryController(t *testing.T) {
+	const data = `12:pids:/user.slice
+10:cpu,cpuacct:/user.slice
+`
+	if _, err := cgroupMemoryPathFromReader(strings.NewReader(data)); err == nil {
+		t.Fatal("expected an error when no memory controller entry is present")
+	}
+}
+
+func TestReadCgroupV2Value(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int64
+	}{
+		{"max\n", -1},
+		{"134217728\n", 134217728},
+		{"134217728", 134217728},
+	}
+	for _, tc := range tests {
+		path := filepath.Join(t.TempDir(), "memory.max")
+		if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		got, err := readCgroupV2Value(path)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tc.content, err)
+		}
+		if got != tc.want {
+			t.Errorf("readCgroupV2Value(%q) = %d, want %d", tc.content, got, tc.want)
+		}
+	}
+}
+
+func TestReadCgroupIntFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.usage_in_bytes")
+	if err := os.WriteFile(path, []byte("67108864\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	got, err := readCgroupIntFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := int64(67108864); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestReadCgroupIntFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.usage_in_bytes")
+	if err := os.WriteFile(path, []byte("not-a-number\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if _, err := readCgroupIntFile(path); err == nil {
+		t.Fatal("expected an error for non-numeric content")
+	}
+}
+
+func TestFileExists(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "present")
+	if err := os.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if !fileExists(existing) {
+		t.Errorf("fileExists(%q) = false, want true", existing)
+	}
+	if fileExists(filepath.Join(dir, "missing")) {
+		t.Error("fileExists on a missing path = true, want false")
+	}
+}
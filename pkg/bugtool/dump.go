@@ -0,0 +1,283 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Tetragon
+
+//go:build linux
+
+package bugtool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"golang.org/x/sys/unix"
+)
+
+// DumpOptions controls how DumpMap decodes and filters the entries of a
+// pinned map.
+type DumpOptions struct {
+	// KeyFilter, if non-nil, is matched against the JSON-rendered key of
+	// each entry; entries whose key doesn't match are skipped.
+	KeyFilter *regexp.Regexp
+	// Limit caps the number of entries written, 0 means unlimited.
+	Limit int
+	// Sample, if > 1, only writes every Nth matching entry.
+	Sample int
+}
+
+// mapEntry is the JSON/YAML shape written by DumpMap for each map entry.
+type mapEntry struct {
+	Key   any `json:"key"`
+	Value any `json:"value"`
+}
+
+// DumpMap decodes and pretty-prints the contents of the map pinned under
+// TetragonBPFFS with the given name, writing one JSON object per entry to w.
+// Keys and values are decoded into nested maps/slices using the BTF type
+// information recorded in the map's kernel bpf_map_info (read with
+// rawMapInfoByFD), falling back to raw hex
+// strings for fields or whole entries that have no BTF information.
+//
+// Output is JSON only: every decoded entry is already a plain
+// map[string]any/[]any/scalar tree, so a YAML encoder would just be a second
+// serialization of the same structure. Since nothing else in this package
+// depends on a YAML library, that's left for a caller to add (e.g. piping
+// this output through a generic JSON-to-YAML converter) rather than pulling
+// in a new dependency here.
+func DumpMap(name string, w io.Writer, opts DumpOptions) error {
+	path := filepath.Join(TetragonBPFFS, name)
+	m, err := ebpf.LoadPinnedMap(path, &ebpf.LoadPinOptions{
+		ReadOnly: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load pinned map %q: %w", path, err)
+	}
+	defer m.Close()
+
+	info, err := m.Info()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve map info: %w", err)
+	}
+
+	keyType, valueType := btfKeyValueTypes(m)
+
+	sample := opts.Sample
+	if sample <= 0 {
+		sample = 1
+	}
+
+	encoder := json.NewEncoder(w)
+	key := make([]byte, info.KeySize)
+	value := make([]byte, info.ValueSize)
+	written, seen := 0, 0
+	it := m.Iterate()
+	for it.Next(&key, &value) {
+		if opts.Limit > 0 && written >= opts.Limit {
+			break
+		}
+
+		entry := mapEntry{
+			Key:   decodeBTFValue(keyType, key),
+			Value: decodeBTFValue(valueType, value),
+		}
+
+		if opts.KeyFilter != nil {
+			keyJSON, err := json.Marshal(entry.Key)
+			if err != nil {
+				return fmt.Errorf("failed to marshal key for filtering: %w", err)
+			}
+			if !opts.KeyFilter.Match(keyJSON) {
+				continue
+			}
+		}
+
+		seen++
+		if seen%sample != 0 {
+			continue
+		}
+
+		if err := encoder.Encode(entry); err != nil {
+			return fmt.Errorf("failed to encode map entry: %w", err)
+		}
+		written++
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("failed to iterate over map %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// rawMapInfo mirrors the kernel UAPI struct bpf_map_info, whose layout is
+// part of the stable bpf(2) ABI. github.com/cilium/ebpf's MapInfo doesn't
+// expose btf_id/btf_key_type_id/btf_value_type_id, so we read them directly
+// with BPF_OBJ_GET_INFO_BY_FD instead of vendoring a newer ebpf release.
+type rawMapInfo struct {
+	Type                  uint32
+	ID                    uint32
+	KeySize               uint32
+	ValueSize             uint32
+	MaxEntries            uint32
+	MapFlags              uint32
+	Name                  [16]byte
+	Ifindex               uint32
+	BTFVmlinuxValueTypeID uint32
+	NetnsDev              uint64
+	NetnsIno              uint64
+	BTFID                 uint32
+	BTFKeyTypeID          uint32
+	BTFValueTypeID        uint32
+	_                     uint32
+	MapExtra              uint64
+}
+
+// bpfObjGetInfoByFD is BPF_OBJ_GET_INFO_BY_FD from the kernel's bpf_cmd enum.
+const bpfObjGetInfoByFD = 15
+
+// rawMapInfoByFD issues a BPF_OBJ_GET_INFO_BY_FD syscall to read the fields
+// of a map's struct bpf_map_info that the ebpf package doesn't surface.
+func rawMapInfoByFD(fd int) (rawMapInfo, error) {
+	var info rawMapInfo
+	attr := struct {
+		fd      uint32
+		infoLen uint32
+		info    uint64
+	}{
+		fd:      uint32(fd),
+		infoLen: uint32(unsafe.Sizeof(info)),
+		info:    uint64(uintptr(unsafe.Pointer(&info))),
+	}
+
+	_, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(bpfObjGetInfoByFD), uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return rawMapInfo{}, fmt.Errorf("BPF_OBJ_GET_INFO_BY_FD: %w", errno)
+	}
+	return info, nil
+}
+
+// btfKeyValueTypes resolves the BTF types of a map's key and value,
+// returning nil for either that isn't available (e.g. the map was loaded
+// without BTF).
+func btfKeyValueTypes(m *ebpf.Map) (key, value btf.Type) {
+	raw, err := rawMapInfoByFD(m.FD())
+	if err != nil || raw.BTFID == 0 {
+		return nil, nil
+	}
+
+	handle, err := btf.NewHandleFromID(btf.ID(raw.BTFID))
+	if err != nil {
+		return nil, nil
+	}
+	defer handle.Close()
+
+	spec, err := handle.Spec(nil)
+	if err != nil {
+		return nil, nil
+	}
+
+	if raw.BTFKeyTypeID != 0 {
+		if t, err := spec.TypeByID(btf.TypeID(raw.BTFKeyTypeID)); err == nil {
+			key = t
+		}
+	}
+	if raw.BTFValueTypeID != 0 {
+		if t, err := spec.TypeByID(btf.TypeID(raw.BTFValueTypeID)); err == nil {
+			value = t
+		}
+	}
+	return key, value
+}
+
+// decodeBTFValue renders raw into a JSON-friendly representation (nested
+// maps/slices/scalars) following typ, falling back to a hex string if typ is
+// nil or not handled.
+func decodeBTFValue(typ btf.Type, raw []byte) any {
+	if typ == nil {
+		return hexString(raw)
+	}
+	return decodeBTFValueOffset(btf.UnderlyingType(typ), raw, 0)
+}
+
+// decodeBTFValueOffset recursively decodes the value of type typ located at
+// the given byte offset into raw.
+func decodeBTFValueOffset(typ btf.Type, raw []byte, offset uint32) any {
+	switch t := typ.(type) {
+	case *btf.Struct:
+		out := make(map[string]any, len(t.Members))
+		for _, m := range t.Members {
+			out[m.Name] = decodeBTFValueOffset(btf.UnderlyingType(m.Type), raw, offset+m.Offset.Bytes())
+		}
+		return out
+	case *btf.Union:
+		out := make(map[string]any, len(t.Members))
+		for _, m := range t.Members {
+			out[m.Name] = decodeBTFValueOffset(btf.UnderlyingType(m.Type), raw, offset+m.Offset.Bytes())
+		}
+		return out
+	case *btf.Array:
+		elemSize := sizeOf(t.Type)
+		out := make([]any, 0, t.Nelems)
+		for i := uint32(0); i < t.Nelems; i++ {
+			out = append(out, decodeBTFValueOffset(btf.UnderlyingType(t.Type), raw, offset+i*elemSize))
+		}
+		return out
+	case *btf.Enum:
+		v := readUint(raw, offset, t.Size)
+		for _, val := range t.Values {
+			if uint64(val.Value) == v {
+				return val.Name
+			}
+		}
+		return v
+	case *btf.Pointer:
+		return fmt.Sprintf("0x%x", readUint(raw, offset, 8))
+	case *btf.Int:
+		return decodeBTFInt(t, raw, offset)
+	default:
+		size := sizeOf(typ)
+		if offset+size > uint32(len(raw)) {
+			return hexString(nil)
+		}
+		return hexString(raw[offset : offset+size])
+	}
+}
+
+func decodeBTFInt(t *btf.Int, raw []byte, offset uint32) any {
+	if t.Encoding == btf.Bool {
+		return readUint(raw, offset, t.Size) != 0
+	}
+	if t.Encoding == btf.Signed {
+		v := readUint(raw, offset, t.Size)
+		shift := 64 - 8*t.Size
+		return int64(v<<shift) >> shift
+	}
+	return readUint(raw, offset, t.Size)
+}
+
+func readUint(raw []byte, offset, size uint32) uint64 {
+	if offset+size > uint32(len(raw)) {
+		return 0
+	}
+	var v uint64
+	for i := uint32(0); i < size; i++ {
+		v |= uint64(raw[offset+i]) << (8 * i)
+	}
+	return v
+}
+
+func sizeOf(typ btf.Type) uint32 {
+	size, err := btf.Sizeof(typ)
+	if err != nil {
+		return 0
+	}
+	return uint32(size)
+}
+
+func hexString(b []byte) string {
+	return fmt.Sprintf("%x", b)
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Tetragon
+
+//go:build linux
+
+package bugtool
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// TestDecodeBTFValueOffsetStruct builds a struct type by hand (pid uint32,
+// running bool, state enum, tags [3]uint32) and checks that
+// decodeBTFValueOffset recursively decodes every member at its correct
+// offset, without needing a real BTF-carrying map.
+func TestDecodeBTFValueOffsetStruct(t *testing.T) {
+	u32 := &btf.Int{Name: "unsigned int", Size: 4, Encoding: btf.Unsigned}
+	boolType := &btf.Int{Name: "bool", Size: 1, Encoding: btf.Bool}
+	state := &btf.Enum{
+		Name: "proc_state",
+		Size: 4,
+		Values: []btf.EnumValue{
+			{Name: "RUNNING", Value: 0},
+			{Name: "STOPPED", Value: 1},
+		},
+	}
+	tags := &btf.Array{Type: u32, Nelems: 3}
+
+	typ := &btf.Struct{
+		Name: "proc_info",
+		Size: 24,
+		Members: []btf.Member{
+			{Name: "pid", Type: u32, Offset: 0},
+			{Name: "running", Type: boolType, Offset: 32},
+			{Name: "state", Type: state, Offset: 64},
+			{Name: "tags", Type: tags, Offset: 96},
+		},
+	}
+
+	raw := []byte{
+		42, 0, 0, 0, // pid = 42
+		1, 0, 0, 0, // running = true (+ padding)
+		1, 0, 0, 0, // state = STOPPED
+		10, 0, 0, 0, // tags[0] = 10
+		20, 0, 0, 0, // tags[1] = 20
+		30, 0, 0, 0, // tags[2] = 30
+	}
+
+	got := decodeBTFValue(typ, raw)
+	want := map[string]any{
+		"pid":     uint64(42),
+		"running": true,
+		"state":   "STOPPED",
+		"tags":    []any{uint64(10), uint64(20), uint64(30)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestDecodeBTFValueOffsetEnumUnknownValue(t *testing.T) {
+	state := &btf.Enum{
+		Name:   "proc_state",
+		Size:   4,
+		Values: []btf.EnumValue{{Name: "RUNNING", Value: 0}},
+	}
+	got := decodeBTFValue(state, []byte{99, 0, 0, 0})
+	if want := uint64(99); got != want {
+		t.Errorf("got %#v, want %#v (unknown enum value should fall back to its raw numeric value)", got, want)
+	}
+}
+
+func TestDecodeBTFValueOffsetSignedInt(t *testing.T) {
+	i32 := &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}
+	// -1 as a little-endian 32-bit two's complement value.
+	got := decodeBTFValue(i32, []byte{0xff, 0xff, 0xff, 0xff})
+	if want := int64(-1); got != want {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestDecodeBTFValueOffsetPointer(t *testing.T) {
+	ptr := &btf.Pointer{Target: &btf.Void{}}
+	got := decodeBTFValue(ptr, []byte{0x10, 0, 0, 0, 0, 0, 0, 0})
+	if want := "0x10"; got != want {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestDecodeBTFValueNilType(t *testing.T) {
+	raw := []byte{1, 2, 3}
+	got := decodeBTFValue(nil, raw)
+	if want := hexString(raw); got != want {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestDecodeBTFValueOffsetTruncatedRaw(t *testing.T) {
+	u32 := &btf.Int{Name: "unsigned int", Size: 4, Encoding: btf.Unsigned}
+	got := decodeBTFValue(u32, []byte{1, 2})
+	if want := uint64(0); got != want {
+		t.Errorf("got %#v, want %#v (reading past the end of raw should return 0, not panic)", got, want)
+	}
+}
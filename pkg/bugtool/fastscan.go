@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Tetragon
+
+//go:build linux
+
+package bugtool
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// fastScanMinKernelVersion is the minimum kernel version (major, minor)
+// where BPF_F_MMAPABLE array maps are supported (5.5).
+var fastScanMinKernelVersion = [2]int{5, 5}
+
+// FastScanArray iterates over an array-type map (BPF_MAP_TYPE_ARRAY, or
+// BPF_MAP_TYPE_ARRAY_OF_MAPS at the outer level) by mmap'ing its data region
+// instead of issuing one syscall per entry via Map.Iterate. On large arrays
+// (hundreds of thousands of entries, as is common for process caches) this
+// cuts scan time by an order of magnitude and lets callers poll more
+// frequently without adding syscall overhead to the datapath.
+//
+// fn is called once per entry with its index and raw bytes; iteration stops
+// early if fn returns false.
+//
+// Per-CPU array maps and ring buffers are rejected outright rather than
+// silently falling back to the raw iterator: the kernel's array_map_mmap
+// isn't wired up for BPF_MAP_TYPE_PERCPU_ARRAY at all (only plain
+// BPF_MAP_TYPE_ARRAY supports BPF_F_MMAPABLE), and ring buffers have no
+// key/value entries to iterate over in the first place, they expose a
+// producer/consumer byte stream instead. Both need their own decoding,
+// which is out of scope for this index/raw-bytes-per-entry function.
+//
+// FastScanArray falls back to m.Iterate() when the map wasn't created with
+// BPF_F_MMAPABLE, when its type isn't one of the array types above, or when
+// the running kernel predates 5.5, the version mmap support for array maps
+// landed in.
+func FastScanArray(m *ebpf.Map, fn func(idx uint32, raw []byte) bool) error {
+	info, err := m.Info()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve map info: %w", err)
+	}
+
+	if unsupportedFastScanType(info.Type) {
+		return fmt.Errorf("unsupported map type %s for FastScanArray: per-CPU arrays and ring buffers need their own decoding, not a flat value_size*index layout", info.Type)
+	}
+
+	if !arrayLike(info.Type) || !supportsMmapArray() {
+		return scanArrayViaIterate(m, info, fn)
+	}
+
+	data, err := mmapArrayMap(m, info)
+	if err != nil {
+		// most likely the map wasn't created with BPF_F_MMAPABLE.
+		return scanArrayViaIterate(m, info, fn)
+	}
+	defer unix.Munmap(data) //nolint:errcheck
+
+	// the kernel stores each array element at a stride rounded up to 8
+	// bytes (see round_up(value_size, 8) in kernel/bpf/arraymap.c),
+	// regardless of the map's nominal value size.
+	valueSize := int(info.ValueSize)
+	stride := roundUp8(valueSize)
+	for i := uint32(0); i < info.MaxEntries; i++ {
+		start := int(i) * stride
+		if start+valueSize > len(data) {
+			break
+		}
+		if !fn(i, data[start:start+valueSize]) {
+			break
+		}
+	}
+	return nil
+}
+
+func roundUp8(n int) int {
+	return (n + 7) &^ 7
+}
+
+// arrayLike reports whether t is a map type laid out as a flat array of
+// fixed-size values that this fast path knows how to stride over.
+func arrayLike(t ebpf.MapType) bool {
+	switch t {
+	case ebpf.Array, ebpf.ArrayOfMaps:
+		return true
+	default:
+		return false
+	}
+}
+
+// unsupportedFastScanType reports whether t is explicitly out of scope for
+// FastScanArray and should be rejected rather than routed to the generic
+// m.Iterate() fallback, which can't decode it correctly either.
+func unsupportedFastScanType(t ebpf.MapType) bool {
+	switch t {
+	case ebpf.PerCPUArray, ebpf.RingBuf:
+		return true
+	default:
+		return false
+	}
+}
+
+func mmapArrayMap(m *ebpf.Map, info *ebpf.MapInfo) ([]byte, error) {
+	size := roundUp8(int(info.ValueSize)) * int(info.MaxEntries)
+	data, err := unix.Mmap(m.FD(), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mmap map fd %d: %w", m.FD(), err)
+	}
+	return data, nil
+}
+
+func scanArrayViaIterate(m *ebpf.Map, info *ebpf.MapInfo, fn func(idx uint32, raw []byte) bool) error {
+	var idx uint32
+	value := make([]byte, info.ValueSize)
+	it := m.Iterate()
+	for it.Next(&idx, &value) {
+		if !fn(idx, value) {
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("failed to iterate over map: %w", err)
+	}
+	return nil
+}
+
+// supportsMmapArray reports whether the running kernel is new enough to
+// support BPF_F_MMAPABLE array maps.
+func supportsMmapArray() bool {
+	major, minor, err := kernelVersion()
+	if err != nil {
+		return false
+	}
+	if major != fastScanMinKernelVersion[0] {
+		return major > fastScanMinKernelVersion[0]
+	}
+	return minor >= fastScanMinKernelVersion[1]
+}
+
+func kernelVersion() (major, minor int, err error) {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return 0, 0, fmt.Errorf("failed to get kernel release: %w", err)
+	}
+	release := unix.ByteSliceToString(uname.Release[:])
+	if _, err := fmt.Sscanf(release, "%d.%d", &major, &minor); err != nil {
+		return 0, 0, fmt.Errorf("failed to parse kernel release %q: %w", release, err)
+	}
+	return major, minor, nil
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Tetragon
+
+//go:build linux
+
+package bugtool
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// TestFastScanArrayMatchesIterate creates a real BPF_F_MMAPABLE array map,
+// populates it, and checks that FastScanArray returns the same values as
+// Map.Iterate, exercising the mmap/stride codepath end to end.
+func TestFastScanArrayMatchesIterate(t *testing.T) {
+	requireBPF(t)
+
+	const maxEntries = 8
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: maxEntries,
+		Flags:      unix.BPF_F_MMAPABLE,
+	})
+	if err != nil {
+		t.Fatalf("failed to create map: %v", err)
+	}
+	defer m.Close()
+
+	for i := uint32(0); i < maxEntries; i++ {
+		if err := m.Put(i, i*10); err != nil {
+			t.Fatalf("failed to populate entry %d: %v", i, err)
+		}
+	}
+
+	want := map[uint32]uint32{}
+	var idx, value uint32
+	it := m.Iterate()
+	for it.Next(&idx, &value) {
+		want[idx] = value
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("failed to iterate over map: %v", err)
+	}
+
+	got := map[uint32]uint32{}
+	err = FastScanArray(m, func(idx uint32, raw []byte) bool {
+		got[idx] = uint32(readUint(raw, 0, 4))
+		return true
+	})
+	if err != nil {
+		t.Fatalf("FastScanArray failed: %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+	for idx, wantValue := range want {
+		if gotValue, ok := got[idx]; !ok || gotValue != wantValue {
+			t.Errorf("entry %d: got %v (present=%v), want %d", idx, gotValue, ok, wantValue)
+		}
+	}
+}
+
+// TestFastScanArrayRejectsUnsupportedType checks that FastScanArray returns
+// an error for map types it explicitly doesn't support, instead of silently
+// misinterpreting their layout.
+func TestFastScanArrayRejectsUnsupportedType(t *testing.T) {
+	requireBPF(t)
+
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.PerCPUArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create map: %v", err)
+	}
+	defer m.Close()
+
+	err = FastScanArray(m, func(uint32, []byte) bool { return true })
+	if err == nil {
+		t.Fatal("expected an error for a per-CPU array map")
+	}
+}
+
+func TestUnsupportedFastScanType(t *testing.T) {
+	tests := []struct {
+		typ  ebpf.MapType
+		want bool
+	}{
+		{ebpf.PerCPUArray, true},
+		{ebpf.RingBuf, true},
+		{ebpf.Array, false},
+		{ebpf.Hash, false},
+		{ebpf.ArrayOfMaps, false},
+	}
+	for _, tc := range tests {
+		if got := unsupportedFastScanType(tc.typ); got != tc.want {
+			t.Errorf("unsupportedFastScanType(%v) = %v, want %v", tc.typ, got, tc.want)
+		}
+	}
+}
+
+func TestArrayLike(t *testing.T) {
+	tests := []struct {
+		typ  ebpf.MapType
+		want bool
+	}{
+		{ebpf.Array, true},
+		{ebpf.ArrayOfMaps, true},
+		{ebpf.Hash, false},
+		{ebpf.PerCPUArray, false},
+		{ebpf.RingBuf, false},
+	}
+	for _, tc := range tests {
+		if got := arrayLike(tc.typ); got != tc.want {
+			t.Errorf("arrayLike(%v) = %v, want %v", tc.typ, got, tc.want)
+		}
+	}
+}
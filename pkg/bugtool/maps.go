@@ -254,9 +254,121 @@ func mapIDsFromPinnedProgs(path string) ([]int, error) {
 		}
 	}
 
+	// follow map-in-map references (ARRAY_OF_MAPS / HASH_OF_MAPS) so that
+	// inner maps referenced only through an outer map-in-map are not
+	// missed, including inner PROG_ARRAY maps and the programs they tail
+	// call into.
+	if err := expandMapInMapReferences(mapSet); err != nil {
+		return nil, fmt.Errorf("failed to expand map-in-map references: %w", err)
+	}
+
 	return maps.Keys(mapSet), nil
 }
 
+// expandMapInMapReferences walks mapSet and, for every ARRAY_OF_MAPS or
+// HASH_OF_MAPS map it finds, resolves and adds the IDs of the maps it
+// references. If an inner map is itself a PROG_ARRAY, the map IDs used by
+// the programs it tail calls into are added too. mapSet is mutated in
+// place. A visited set guards against cycles in the map-in-map hierarchy.
+func expandMapInMapReferences(mapSet map[int]bool) error {
+	visited := map[int]bool{}
+	queue := make([]int, 0, len(mapSet))
+	for id := range mapSet {
+		queue = append(queue, id)
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+
+		innerMapIDs, progArrayProgIDs, err := mapInMapReferences(id)
+		if err != nil {
+			return err
+		}
+
+		for _, innerID := range innerMapIDs {
+			if !mapSet[innerID] {
+				mapSet[innerID] = true
+				queue = append(queue, innerID)
+			}
+		}
+
+		for _, progID := range progArrayProgIDs {
+			prog, err := ebpf.NewProgramFromID(ebpf.ProgramID(progID))
+			if err != nil {
+				return fmt.Errorf("failed to create new program from id %d: %w", progID, err)
+			}
+			newIDs, err := mapIDsFromProgs(prog)
+			prog.Close()
+			if err != nil {
+				return fmt.Errorf("failed to retrieve map IDs from prog: %w", err)
+			}
+			for _, newID := range newIDs {
+				if !mapSet[newID] {
+					mapSet[newID] = true
+					queue = append(queue, newID)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// mapInMapReferences returns the map IDs referenced by the map identified by
+// id if it is an ARRAY_OF_MAPS or HASH_OF_MAPS, and, for any of those inner
+// maps that is itself a PROG_ARRAY, the program IDs held in its tail-call
+// slots. It returns two nil slices for any other map type.
+func mapInMapReferences(id int) (innerMapIDs []int, progArrayProgIDs []int, err error) {
+	m, err := ebpf.NewMapFromID(ebpf.MapID(id))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed creating a map FD from ID %d: %w", id, err)
+	}
+	defer m.Close()
+
+	if m.Type() != ebpf.ArrayOfMaps && m.Type() != ebpf.HashOfMaps {
+		return nil, nil, nil
+	}
+
+	info, err := m.Info()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed retrieving info from map (id %d): %w", id, err)
+	}
+
+	key := make([]byte, info.KeySize)
+	var innerID uint32
+	it := m.Iterate()
+	for it.Next(&key, &innerID) {
+		innerMapIDs = append(innerMapIDs, int(innerID))
+
+		innerMap, err := ebpf.NewMapFromID(ebpf.MapID(innerID))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed creating inner map FD from ID %d: %w", innerID, err)
+		}
+		if innerMap.Type() == ebpf.ProgramArray {
+			var progArrayKey, progID uint32
+			progIt := innerMap.Iterate()
+			for progIt.Next(&progArrayKey, &progID) {
+				progArrayProgIDs = append(progArrayProgIDs, int(progID))
+			}
+			if err := progIt.Err(); err != nil {
+				innerMap.Close()
+				return nil, nil, fmt.Errorf("failed to iterate over inner prog array map (id %d): %w", innerID, err)
+			}
+		}
+		innerMap.Close()
+	}
+	if err := it.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to iterate over map-in-map (id %d): %w", id, err)
+	}
+
+	return innerMapIDs, progArrayProgIDs, nil
+}
+
 func memlockInfoFromMapID(id int) (ExtendedMapInfo, error) {
 	m, err := ebpf.NewMapFromID(ebpf.MapID(id))
 	if err != nil {
@@ -335,14 +447,17 @@ type DiffMap struct {
 }
 
 type AggregatedMap struct {
-	Name           string  `json:"name,omitempty"`
-	Type           string  `json:"type,omitempty"`
-	KeySize        int     `json:"key_size,omitempty"`
-	ValueSize      int     `json:"value_size,omitempty"`
-	MaxEntries     int     `json:"max_entries,omitempty"`
-	Count          int     `json:"count,omitempty"`
-	TotalMemlock   int     `json:"total_memlock,omitempty"`
-	PercentOfTotal float64 `json:"percent_of_total,omitempty"`
+	Name                 string  `json:"name,omitempty"`
+	Type                 string  `json:"type,omitempty"`
+	KeySize              int     `json:"key_size,omitempty"`
+	ValueSize            int     `json:"value_size,omitempty"`
+	MaxEntries           int     `json:"max_entries,omitempty"`
+	Count                int     `json:"count,omitempty"`
+	TotalMemlock         int     `json:"total_memlock,omitempty"`
+	PercentOfTotal       float64 `json:"percent_of_total,omitempty"`
+	CgroupMemoryLimit    int64   `json:"cgroup_memory_limit,omitempty"`
+	CgroupMemoryCurrent  int64   `json:"cgroup_memory_current,omitempty"`
+	PercentOfCgroupLimit float64 `json:"percent_of_cgroup_limit,omitempty"`
 }
 
 type MapsChecksOutput struct {
@@ -364,9 +479,36 @@ type MapsChecksOutput struct {
 	DiffMaps []DiffMap `json:"diff_maps,omitempty"`
 
 	AggregatedMaps []AggregatedMap `json:"aggregated_maps,omitempty"`
+
+	// Cgroup is the memory limit/usage of the cgroup tetragon is running
+	// in, or nil if it could not be determined (e.g. no cgroup memory
+	// controller available).
+	Cgroup *CgroupMemoryInfo `json:"cgroup,omitempty"`
+
+	// Warnings contains human readable alerts, such as pinned-maps
+	// memlock approaching the cgroup memory limit.
+	Warnings []string `json:"warnings,omitempty"`
 }
 
+// MapsChecksOptions configures RunMapsChecksWithOptions.
+type MapsChecksOptions struct {
+	// MemlockPressureThreshold is the fraction (0-1) of the cgroup memory
+	// limit above which pinned-maps memlock usage triggers a warning. A
+	// zero value uses DefaultMemlockPressureThreshold.
+	MemlockPressureThreshold float64
+}
+
+// RunMapsChecks runs the maps checks with the default options.
 func RunMapsChecks() (*MapsChecksOutput, error) {
+	return RunMapsChecksWithOptions(MapsChecksOptions{})
+}
+
+func RunMapsChecksWithOptions(opts MapsChecksOptions) (*MapsChecksOutput, error) {
+	threshold := opts.MemlockPressureThreshold
+	if threshold == 0 {
+		threshold = DefaultMemlockPressureThreshold
+	}
+
 	// check that the bpffs exists and we have permissions
 	_, err := os.Stat(TetragonBPFFS)
 	if err != nil {
@@ -464,8 +606,13 @@ func RunMapsChecks() (*MapsChecksOutput, error) {
 		return aggregatedMaps[i].Memlock > aggregatedMaps[j].Memlock
 	})
 
+	cgroupMem, cgroupErr := currentCgroupMemoryInfo()
+	if cgroupErr == nil {
+		out.Cgroup = &cgroupMem
+	}
+
 	for _, m := range aggregatedMaps {
-		out.AggregatedMaps = append(out.AggregatedMaps, AggregatedMap{
+		agg := AggregatedMap{
 			Name:           m.Name,
 			Type:           m.Type.String(),
 			KeySize:        int(m.KeySize),
@@ -474,7 +621,27 @@ func RunMapsChecks() (*MapsChecksOutput, error) {
 			Count:          m.count,
 			TotalMemlock:   m.Memlock,
 			PercentOfTotal: float64(m.Memlock) / float64(total) * 100,
-		})
+		}
+		if cgroupErr == nil && cgroupMem.Limit > 0 {
+			agg.CgroupMemoryLimit = cgroupMem.Limit
+			agg.CgroupMemoryCurrent = cgroupMem.Current
+			agg.PercentOfCgroupLimit = float64(m.Memlock) / float64(cgroupMem.Limit) * 100
+			if agg.PercentOfCgroupLimit/100 >= threshold {
+				out.Warnings = append(out.Warnings, fmt.Sprintf(
+					"map %q memlock (%d bytes) is at %.1f%% of the cgroup memory limit (%d bytes)",
+					m.Name, m.Memlock, agg.PercentOfCgroupLimit, cgroupMem.Limit))
+			}
+		}
+		out.AggregatedMaps = append(out.AggregatedMaps, agg)
+	}
+
+	if cgroupErr == nil && cgroupMem.Limit > 0 {
+		pinnedPercent := float64(out.TotalByteMemlock.PinnedMaps) / float64(cgroupMem.Limit) * 100
+		if pinnedPercent/100 >= threshold {
+			out.Warnings = append(out.Warnings, fmt.Sprintf(
+				"pinned maps total memlock (%d bytes) is at %.1f%% of the cgroup memory limit (%d bytes)",
+				out.TotalByteMemlock.PinnedMaps, pinnedPercent, cgroupMem.Limit))
+		}
 	}
 
 	return &out, nil
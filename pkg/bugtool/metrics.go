@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Tetragon
+
+package bugtool
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+const mapsCollectorSubsystem = "bpf"
+
+var (
+	mapMemlockDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("tetragon", mapsCollectorSubsystem, "map_memlock_bytes"),
+		"Memory locked by a pinned BPF map, in bytes.",
+		[]string{"name", "type", "id"}, nil,
+	)
+	mapMaxEntriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("tetragon", mapsCollectorSubsystem, "map_max_entries"),
+		"Configured maximum number of entries of a pinned BPF map.",
+		[]string{"name", "type", "id"}, nil,
+	)
+	mapDiffCountDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("tetragon", mapsCollectorSubsystem, "map_diff_count"),
+		"Number of maps pinned under the bpffs but not referenced by any pinned program.",
+		nil, nil,
+	)
+)
+
+// MapsCollector is a prometheus.Collector that reports BPF map memory usage,
+// entry counts and pinned-vs-loaded deltas for all maps pinned under
+// TetragonBPFFS. Use NewMapsCollector to construct one, or
+// RegisterMapsCollector to construct and register it in one call from the
+// Tetragon metrics server's setup code.
+type MapsCollector struct {
+	limiter *rate.Limiter
+	// compute is swapped out in tests to drive refresh's caching/rate-limiting
+	// behavior without touching the real TetragonBPFFS.
+	compute func() ([]ExtendedMapInfo, int, error)
+
+	mu         sync.Mutex
+	pinnedMaps []ExtendedMapInfo
+	diffCount  int
+	lastErr    error
+}
+
+// NewMapsCollector returns a MapsCollector that re-runs FindPinnedMaps and
+// FindMapsUsedByPinnedProgs on every Collect call, no more often than once
+// per minInterval, to keep the cost of walking TetragonBPFFS and parsing
+// fdinfo off the hot path when scraped frequently. Between refreshes it
+// serves the last computed values (or error) straight from memory.
+func NewMapsCollector(minInterval time.Duration) *MapsCollector {
+	return &MapsCollector{
+		limiter: rate.NewLimiter(rate.Every(minInterval), 1),
+		compute: computeMapsAndDiff,
+	}
+}
+
+// RegisterMapsCollector constructs a MapsCollector and registers it on reg,
+// so that Tetragon's metrics endpoint starts serving the
+// tetragon_bpf_map_* series alongside the rest of its gauges.
+func RegisterMapsCollector(reg prometheus.Registerer, minInterval time.Duration) *MapsCollector {
+	c := NewMapsCollector(minInterval)
+	reg.MustRegister(c)
+	return c
+}
+
+// Describe implements prometheus.Collector.
+func (c *MapsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- mapMemlockDesc
+	ch <- mapMaxEntriesDesc
+	ch <- mapDiffCountDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *MapsCollector) Collect(ch chan<- prometheus.Metric) {
+	pinnedMaps, diffCount, err := c.refresh()
+	if err != nil {
+		return
+	}
+
+	for _, m := range pinnedMaps {
+		id, _ := m.ID()
+		labels := []string{m.Name, m.Type.String(), strconv.Itoa(int(id))}
+		ch <- prometheus.MustNewConstMetric(mapMemlockDesc, prometheus.GaugeValue, float64(m.Memlock), labels...)
+		ch <- prometheus.MustNewConstMetric(mapMaxEntriesDesc, prometheus.GaugeValue, float64(m.MaxEntries), labels...)
+	}
+
+	ch <- prometheus.MustNewConstMetric(mapDiffCountDesc, prometheus.GaugeValue, float64(diffCount))
+}
+
+// refresh returns the last computed set of pinned maps and diff count,
+// recomputing them if the rate limiter allows, falling back to the cached
+// values (or cached error) otherwise. The limiter gates recomputation
+// regardless of whether the previous attempt succeeded, so a failing
+// TetragonBPFFS (e.g. tetragon briefly down) doesn't turn every scrape into
+// a full walk-and-parse again.
+func (c *MapsCollector) refresh() ([]ExtendedMapInfo, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.limiter.Allow() {
+		return c.pinnedMaps, c.diffCount, c.lastErr
+	}
+
+	pinnedMaps, diffCount, err := c.compute()
+	c.lastErr = err
+	if err != nil {
+		return c.pinnedMaps, c.diffCount, err
+	}
+
+	c.pinnedMaps = pinnedMaps
+	c.diffCount = diffCount
+	return c.pinnedMaps, c.diffCount, nil
+}
+
+// computeMapsAndDiff retrieves the pinned maps under TetragonBPFFS along
+// with the count of maps pinned but not reachable from any pinned program
+// (maps reachable from pinned programs but not pinned are not counted), without the extra host-wide FindAllMaps walk that
+// RunMapsChecks does for its own, richer report.
+func computeMapsAndDiff() ([]ExtendedMapInfo, int, error) {
+	pinnedMaps, err := FindPinnedMaps(TetragonBPFFS)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to retrieve pinned maps: %w", err)
+	}
+	pinnedProgsMaps, err := FindMapsUsedByPinnedProgs(TetragonBPFFS)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to retrieve maps used by pinned progs: %w", err)
+	}
+
+	pinnedSet := map[int]ExtendedMapInfo{}
+	for _, m := range pinnedMaps {
+		if id, ok := m.ID(); ok {
+			pinnedSet[int(id)] = m
+		}
+	}
+	progsSet := map[int]ExtendedMapInfo{}
+	for _, m := range pinnedProgsMaps {
+		if id, ok := m.ID(); ok {
+			progsSet[int(id)] = m
+		}
+	}
+
+	return pinnedMaps, len(diff(pinnedSet, progsSet)), nil
+}
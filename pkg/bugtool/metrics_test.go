@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Tetragon
+
+package bugtool
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// TestMapsCollectorRefreshCachesBetweenTicks checks that refresh only calls
+// compute when the limiter allows it, serving the cached values the rest of
+// the time. The cached-error case is covered by
+// TestMapsCollectorRefreshCachesError.
+func TestMapsCollectorRefreshCachesBetweenTicks(t *testing.T) {
+	calls := 0
+	c := &MapsCollector{
+		limiter: rate.NewLimiter(rate.Every(time.Hour), 1),
+		compute: func() ([]ExtendedMapInfo, int, error) {
+			calls++
+			return []ExtendedMapInfo{{Memlock: calls}}, calls, nil
+		},
+	}
+
+	maps, diffCount, err := c.refresh()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("got %d compute calls, want 1", calls)
+	}
+	if diffCount != 1 || len(maps) != 1 || maps[0].Memlock != 1 {
+		t.Fatalf("got diffCount=%d maps=%+v, want diffCount=1 maps=[{Memlock:1}]", diffCount, maps)
+	}
+
+	// The limiter's burst is exhausted, so a second call within the same
+	// interval must be served from cache instead of calling compute again.
+	maps, diffCount, err = c.refresh()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("got %d compute calls, want 1 (cached)", calls)
+	}
+	if diffCount != 1 || len(maps) != 1 || maps[0].Memlock != 1 {
+		t.Fatalf("got diffCount=%d maps=%+v, want the cached values from the first call", diffCount, maps)
+	}
+}
+
+// TestMapsCollectorRefreshCachesError checks that a failed recompute is also
+// rate-limited: refresh must not retry compute on every call just because
+// the last attempt errored, and must keep returning that cached error until
+// the limiter allows another attempt.
+func TestMapsCollectorRefreshCachesError(t *testing.T) {
+	wantErr := errors.New("boom")
+	calls := 0
+	c := &MapsCollector{
+		limiter: rate.NewLimiter(rate.Every(time.Hour), 1),
+		compute: func() ([]ExtendedMapInfo, int, error) {
+			calls++
+			return nil, 0, wantErr
+		},
+	}
+
+	if _, _, err := c.refresh(); !errors.Is(err, wantErr) {
+		t.Fatalf("got err %v, want %v", err, wantErr)
+	}
+	if _, _, err := c.refresh(); !errors.Is(err, wantErr) {
+		t.Fatalf("got err %v, want cached %v", err, wantErr)
+	}
+	if calls != 1 {
+		t.Fatalf("got %d compute calls, want 1 (error should be cached, not retried every call)", calls)
+	}
+}
+
+// TestMapsCollectorRefreshRecomputesAfterInterval checks that once the
+// limiter allows it, refresh calls compute again rather than serving a
+// stale cache forever.
+func TestMapsCollectorRefreshRecomputesAfterInterval(t *testing.T) {
+	calls := 0
+	c := &MapsCollector{
+		limiter: rate.NewLimiter(rate.Inf, 1),
+		compute: func() ([]ExtendedMapInfo, int, error) {
+			calls++
+			return nil, calls, nil
+		},
+	}
+
+	if _, diffCount, err := c.refresh(); err != nil || diffCount != 1 {
+		t.Fatalf("got diffCount=%d err=%v, want 1, nil", diffCount, err)
+	}
+	if _, diffCount, err := c.refresh(); err != nil || diffCount != 2 {
+		t.Fatalf("got diffCount=%d err=%v, want 2, nil", diffCount, err)
+	}
+	if calls != 2 {
+		t.Fatalf("got %d compute calls, want 2", calls)
+	}
+}
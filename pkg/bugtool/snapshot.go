@@ -0,0 +1,398 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Tetragon
+
+//go:build linux
+
+package bugtool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+)
+
+// snapshotMagic identifies a Tetragon map snapshot file.
+const snapshotMagic = "TGSNAP01"
+
+// snapshotHeader is written at the start of every per-map snapshot file and
+// is used by RestoreMaps to validate compatibility before loading records
+// into the live map.
+type snapshotHeader struct {
+	// Name is the map's pin path relative to TetragonBPFFS.
+	Name       string
+	Type       ebpf.MapType
+	KeySize    uint32
+	ValueSize  uint32
+	MaxEntries uint32
+	BTFHash    string
+}
+
+// RestoreOptions controls the behavior of RestoreMaps.
+type RestoreOptions struct {
+	// DryRun, if true, validates and reports what would be loaded
+	// without writing anything to the live maps.
+	DryRun bool
+}
+
+// RestoreReport summarizes the outcome of restoring (or dry-running) one
+// snapshot file.
+type RestoreReport struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// SnapshotMaps serializes the key/value pairs of every map pinned under
+// TetragonBPFFS into dir, one file per map, so that they can be reloaded on
+// the next start with RestoreMaps. This preserves state such as the process
+// cache, cgroup-to-pod translations, or per-policy counters across agent
+// restarts.
+func SnapshotMaps(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory %q: %w", dir, err)
+	}
+
+	err := filepath.WalkDir(TetragonBPFFS, func(path string, d fs.DirEntry, _ error) error {
+		if d.IsDir() {
+			return nil // skip directories
+		}
+		m, err := ebpf.LoadPinnedMap(path, &ebpf.LoadPinOptions{
+			ReadOnly: true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to load pinned map %q: %w", path, err)
+		}
+		defer m.Close()
+
+		if ok, err := isMap(m.FD()); err != nil || !ok {
+			if err != nil {
+				return err
+			}
+			return nil // skip non map
+		}
+
+		name := filepath.Base(path)
+		pinName, err := filepath.Rel(TetragonBPFFS, path)
+		if err != nil {
+			return fmt.Errorf("failed to compute pin name of %q: %w", path, err)
+		}
+
+		if isPerCPUMapType(m.Type()) {
+			// per-CPU maps store one value per CPU and need their own
+			// on-disk framing (CPU count, per-CPU record layout) to
+			// round trip correctly; skip them for now instead of
+			// failing the whole snapshot on the generic codepath below.
+			return nil
+		}
+
+		if isFDReferencingMapType(m.Type()) {
+			// BPF_MAP_LOOKUP_ELEM returns a map/program ID for these
+			// types, but BPF_MAP_UPDATE_ELEM requires an open fd
+			// referencing that map/program, not the raw ID bytes.
+			// Snapshotting the ID as-is would produce a file that either
+			// fails to restore or, worse, silently references whatever
+			// unrelated fd happens to have that number in the restoring
+			// process. Skip for now; restoring these would need to
+			// re-open the referenced map/program by ID and pass it to
+			// Put as *ebpf.Map/*ebpf.Program.
+			return nil
+		}
+
+		if err := snapshotOneMap(m, pinName, filepath.Join(dir, name+".snap")); err != nil {
+			return fmt.Errorf("failed to snapshot map %q: %w", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// isPerCPUMapType reports whether t stores one value per CPU rather than a
+// single flat value, which m.Iterate() can't decode into a plain []byte
+// value buffer.
+func isPerCPUMapType(t ebpf.MapType) bool {
+	switch t {
+	case ebpf.PerCPUHash, ebpf.PerCPUArray, ebpf.LRUCPUHash, ebpf.PerCPUCGroupStorage:
+		return true
+	default:
+		return false
+	}
+}
+
+// isFDReferencingMapType reports whether t is a map type whose values are
+// references to other kernel objects: BPF_MAP_LOOKUP_ELEM returns a map or
+// program ID for these types, but BPF_MAP_UPDATE_ELEM requires the value to
+// contain an open fd referencing that map or program, not the ID. Neither
+// SnapshotMaps nor RestoreMaps handle this ID-to-fd translation yet.
+func isFDReferencingMapType(t ebpf.MapType) bool {
+	switch t {
+	case ebpf.ArrayOfMaps, ebpf.HashOfMaps, ebpf.ProgramArray:
+		return true
+	default:
+		return false
+	}
+}
+
+func snapshotOneMap(m *ebpf.Map, pinName, path string) error {
+	info, err := m.Info()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve map info: %w", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file %q: %w", path, err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	header := snapshotHeader{
+		Name:       pinName,
+		Type:       info.Type,
+		KeySize:    info.KeySize,
+		ValueSize:  info.ValueSize,
+		MaxEntries: info.MaxEntries,
+		BTFHash:    btfHash(m.FD()),
+	}
+	if err := writeSnapshotHeader(w, header); err != nil {
+		return fmt.Errorf("failed to write snapshot header: %w", err)
+	}
+
+	key := make([]byte, info.KeySize)
+	value := make([]byte, info.ValueSize)
+	it := m.Iterate()
+	for it.Next(&key, &value) {
+		if err := writeSnapshotRecord(w, key, value); err != nil {
+			return fmt.Errorf("failed to write snapshot record: %w", err)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("failed to iterate over map %q: %w", info.Name, err)
+	}
+
+	return w.Flush()
+}
+
+// RestoreMaps reads back the snapshots written by SnapshotMaps from dir and
+// loads their key/value pairs into the corresponding maps pinned under
+// TetragonBPFFS. A snapshot is refused (and reported as skipped) if its
+// MapType, KeySize, ValueSize, or MaxEntries don't match the live map,
+// since that means the map definition changed between the snapshot and
+// this restore.
+func RestoreMaps(dir string, opts RestoreOptions) ([]RestoreReport, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory %q: %w", dir, err)
+	}
+
+	var reports []RestoreReport
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		report, err := restoreOneSnapshot(filepath.Join(dir, e.Name()), opts)
+		if err != nil {
+			return reports, fmt.Errorf("failed to restore snapshot %q: %w", e.Name(), err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func restoreOneSnapshot(path string, opts RestoreOptions) (RestoreReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return RestoreReport{}, fmt.Errorf("failed to open snapshot file %q: %w", path, err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header, err := readSnapshotHeader(r)
+	if err != nil {
+		return RestoreReport{}, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+
+	report := RestoreReport{Name: header.Name}
+
+	if isFDReferencingMapType(header.Type) {
+		report.Skipped = true
+		report.Reason = fmt.Sprintf("restoring %s maps is not supported: values are fd references, not plain bytes", header.Type)
+		return report, nil
+	}
+
+	mapPath := filepath.Join(TetragonBPFFS, header.Name)
+	m, err := ebpf.LoadPinnedMap(mapPath, &ebpf.LoadPinOptions{})
+	if err != nil {
+		report.Skipped = true
+		report.Reason = fmt.Sprintf("failed to load live map %q: %v", mapPath, err)
+		return report, nil
+	}
+	defer m.Close()
+
+	liveInfo, err := m.Info()
+	if err != nil {
+		return RestoreReport{}, fmt.Errorf("failed to retrieve live map info: %w", err)
+	}
+
+	if reason, ok := compatibleWithSnapshot(liveInfo, m.FD(), header); !ok {
+		report.Skipped = true
+		report.Reason = reason
+		return report, nil
+	}
+
+	for {
+		key, value, err := readSnapshotRecord(r, header.KeySize, header.ValueSize)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to read snapshot record: %w", err)
+		}
+		report.Records++
+
+		if opts.DryRun {
+			continue
+		}
+		if err := m.Put(key, value); err != nil {
+			return report, fmt.Errorf("failed to restore record %d into map %q: %w", report.Records, header.Name, err)
+		}
+	}
+
+	return report, nil
+}
+
+func compatibleWithSnapshot(live *ebpf.MapInfo, liveFD int, header snapshotHeader) (string, bool) {
+	if live.Type != header.Type {
+		return fmt.Sprintf("map type mismatch: live=%s snapshot=%s", live.Type, header.Type), false
+	}
+	if live.KeySize != header.KeySize {
+		return fmt.Sprintf("key size mismatch: live=%d snapshot=%d", live.KeySize, header.KeySize), false
+	}
+	if live.ValueSize != header.ValueSize {
+		return fmt.Sprintf("value size mismatch: live=%d snapshot=%d", live.ValueSize, header.ValueSize), false
+	}
+	if live.MaxEntries != header.MaxEntries {
+		return fmt.Sprintf("max entries mismatch: live=%d snapshot=%d", live.MaxEntries, header.MaxEntries), false
+	}
+	if liveHash := btfHash(liveFD); header.BTFHash != "" && liveHash != "" && liveHash != header.BTFHash {
+		return fmt.Sprintf("BTF hash mismatch: live=%s snapshot=%s", liveHash, header.BTFHash), false
+	}
+	return "", true
+}
+
+// btfHash returns a short identifier for the BTF of a map's key/value types,
+// or the empty string if the map has no BTF. It is used as a best-effort
+// compatibility signal on restore, in addition to the type/key/value/max
+// entries checks. fd must refer to the map whose BTF is being identified.
+func btfHash(fd int) string {
+	raw, err := rawMapInfoByFD(fd)
+	if err != nil || (raw.BTFKeyTypeID == 0 && raw.BTFValueTypeID == 0) {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", raw.BTFKeyTypeID, raw.BTFValueTypeID)
+}
+
+func writeSnapshotHeader(w io.Writer, h snapshotHeader) error {
+	if _, err := io.WriteString(w, snapshotMagic); err != nil {
+		return err
+	}
+	for _, s := range []string{h.Name, h.BTFHash} {
+		if err := writeLengthPrefixed(w, []byte(s)); err != nil {
+			return err
+		}
+	}
+	for _, v := range []uint32{uint32(h.Type), h.KeySize, h.ValueSize, h.MaxEntries} {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSnapshotHeader(r io.Reader) (snapshotHeader, error) {
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return snapshotHeader{}, fmt.Errorf("failed to read magic: %w", err)
+	}
+	if string(magic) != snapshotMagic {
+		return snapshotHeader{}, fmt.Errorf("unrecognized snapshot file format %q", magic)
+	}
+
+	name, err := readLengthPrefixed(r)
+	if err != nil {
+		return snapshotHeader{}, fmt.Errorf("failed to read name: %w", err)
+	}
+	btfHash, err := readLengthPrefixed(r)
+	if err != nil {
+		return snapshotHeader{}, fmt.Errorf("failed to read BTF hash: %w", err)
+	}
+
+	var mapType, keySize, valueSize, maxEntries uint32
+	for _, v := range []*uint32{&mapType, &keySize, &valueSize, &maxEntries} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return snapshotHeader{}, fmt.Errorf("failed to read size field: %w", err)
+		}
+	}
+
+	return snapshotHeader{
+		Name:       string(name),
+		Type:       ebpf.MapType(mapType),
+		KeySize:    keySize,
+		ValueSize:  valueSize,
+		MaxEntries: maxEntries,
+		BTFHash:    string(btfHash),
+	}, nil
+}
+
+func writeSnapshotRecord(w io.Writer, key, value []byte) error {
+	if err := writeLengthPrefixed(w, key); err != nil {
+		return err
+	}
+	return writeLengthPrefixed(w, value)
+}
+
+func readSnapshotRecord(r io.Reader, keySize, valueSize uint32) (key, value []byte, err error) {
+	key, err = readLengthPrefixed(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err = readLengthPrefixed(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint32(len(key)) != keySize || uint32(len(value)) != valueSize {
+		return nil, nil, fmt.Errorf("record size mismatch: got key=%d value=%d, want key=%d value=%d",
+			len(key), len(value), keySize, valueSize)
+	}
+	return key, value, nil
+}
+
+func writeLengthPrefixed(w io.Writer, b []byte) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readLengthPrefixed(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Tetragon
+
+//go:build linux
+
+package bugtool
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+func TestSnapshotHeaderRoundTrip(t *testing.T) {
+	want := snapshotHeader{
+		Name:       "test_map",
+		Type:       ebpf.Hash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 1024,
+		BTFHash:    "12-34",
+	}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := writeSnapshotHeader(w, want); err != nil {
+		t.Fatalf("writeSnapshotHeader failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	got, err := readSnapshotHeader(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("readSnapshotHeader failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("got header %+v, want %+v", got, want)
+	}
+}
+
+func TestSnapshotHeaderRejectsWrongMagic(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader([]byte("NOTASNAP")))
+	if _, err := readSnapshotHeader(r); err == nil {
+		t.Fatal("expected an error for an unrecognized magic")
+	}
+}
+
+func TestSnapshotRecordRoundTrip(t *testing.T) {
+	key := []byte{1, 2, 3, 4}
+	value := []byte{5, 6, 7, 8, 9, 10, 11, 12}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := writeSnapshotRecord(w, key, value); err != nil {
+		t.Fatalf("writeSnapshotRecord failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	gotKey, gotValue, err := readSnapshotRecord(bufio.NewReader(&buf), uint32(len(key)), uint32(len(value)))
+	if err != nil {
+		t.Fatalf("readSnapshotRecord failed: %v", err)
+	}
+	if !bytes.Equal(gotKey, key) {
+		t.Errorf("got key %v, want %v", gotKey, key)
+	}
+	if !bytes.Equal(gotValue, value) {
+		t.Errorf("got value %v, want %v", gotValue, value)
+	}
+}
+
+func TestSnapshotRecordSizeMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := writeSnapshotRecord(w, []byte{1, 2, 3, 4}, []byte{5, 6}); err != nil {
+		t.Fatalf("writeSnapshotRecord failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	if _, _, err := readSnapshotRecord(bufio.NewReader(&buf), 4, 4); err == nil {
+		t.Fatal("expected a record size mismatch error")
+	}
+}
+
+func TestReadSnapshotRecordEOF(t *testing.T) {
+	_, _, err := readSnapshotRecord(bufio.NewReader(bytes.NewReader(nil)), 4, 4)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("got err %v, want io.EOF", err)
+	}
+}
+
+func TestIsPerCPUMapType(t *testing.T) {
+	tests := []struct {
+		typ  ebpf.MapType
+		want bool
+	}{
+		{ebpf.PerCPUHash, true},
+		{ebpf.PerCPUArray, true},
+		{ebpf.LRUCPUHash, true},
+		{ebpf.PerCPUCGroupStorage, true},
+		{ebpf.Hash, false},
+		{ebpf.Array, false},
+		{ebpf.ArrayOfMaps, false},
+	}
+	for _, tc := range tests {
+		if got := isPerCPUMapType(tc.typ); got != tc.want {
+			t.Errorf("isPerCPUMapType(%v) = %v, want %v", tc.typ, got, tc.want)
+		}
+	}
+}
+
+func TestIsFDReferencingMapType(t *testing.T) {
+	tests := []struct {
+		typ  ebpf.MapType
+		want bool
+	}{
+		{ebpf.ArrayOfMaps, true},
+		{ebpf.HashOfMaps, true},
+		{ebpf.ProgramArray, true},
+		{ebpf.Hash, false},
+		{ebpf.Array, false},
+		{ebpf.PerCPUHash, false},
+	}
+	for _, tc := range tests {
+		if got := isFDReferencingMapType(tc.typ); got != tc.want {
+			t.Errorf("isFDReferencingMapType(%v) = %v, want %v", tc.typ, got, tc.want)
+		}
+	}
+}
+
+// TestRestoreOneSnapshotSkipsFDReferencingTypes checks that a snapshot file
+// for an fd-referencing map type (e.g. PROG_ARRAY, written by some prior
+// version of SnapshotMaps, or crafted by hand) is reported as skipped rather
+// than attempted, since its on-disk values are IDs, not the fds
+// BPF_MAP_UPDATE_ELEM requires.
+func TestRestoreOneSnapshotSkipsFDReferencingTypes(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/prog_array.snap"
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create snapshot file: %v", err)
+	}
+	w := bufio.NewWriter(f)
+	header := snapshotHeader{
+		Name:       "prog_array",
+		Type:       ebpf.ProgramArray,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	}
+	if err := writeSnapshotHeader(w, header); err != nil {
+		t.Fatalf("writeSnapshotHeader failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	f.Close()
+
+	report, err := restoreOneSnapshot(path, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("restoreOneSnapshot failed: %v", err)
+	}
+	if !report.Skipped {
+		t.Errorf("expected report to be skipped, got %+v", report)
+	}
+}
+
+func TestCompatibleWithSnapshot(t *testing.T) {
+	header := snapshotHeader{
+		Type:       ebpf.Hash,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 16,
+	}
+
+	tests := []struct {
+		name string
+		live ebpf.MapInfo
+		want bool
+	}{
+		{"matching", ebpf.MapInfo{Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 16}, true},
+		{"type mismatch", ebpf.MapInfo{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 16}, false},
+		{"key size mismatch", ebpf.MapInfo{Type: ebpf.Hash, KeySize: 8, ValueSize: 8, MaxEntries: 16}, false},
+		{"value size mismatch", ebpf.MapInfo{Type: ebpf.Hash, KeySize: 4, ValueSize: 4, MaxEntries: 16}, false},
+		{"max entries mismatch", ebpf.MapInfo{Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 8}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			live := tc.live
+			_, ok := compatibleWithSnapshot(&live, -1, header)
+			if ok != tc.want {
+				t.Errorf("compatibleWithSnapshot() ok = %v, want %v", ok, tc.want)
+			}
+		})
+	}
+}